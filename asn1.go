@@ -0,0 +1,220 @@
+// Copyright 2014 Hein Meling and Haibin Zhang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urs
+
+import (
+	encoding_asn1 "encoding/asn1"
+	"crypto/elliptic"
+	"errors"
+	"math/big"
+
+	"golang.org/x/crypto/cryptobyte"
+	casn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// Named curve OIDs, as assigned by [SECG] and used by crypto/x509 to
+// identify the curve a key or signature was generated on.
+var (
+	oidNamedCurveP224 = encoding_asn1.ObjectIdentifier{1, 3, 132, 0, 33}
+	oidNamedCurveP256 = encoding_asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384 = encoding_asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidNamedCurveP521 = encoding_asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+)
+
+func oidFromCurve(curve elliptic.Curve) (encoding_asn1.ObjectIdentifier, bool) {
+	switch curve {
+	case elliptic.P224():
+		return oidNamedCurveP224, true
+	case elliptic.P256():
+		return oidNamedCurveP256, true
+	case elliptic.P384():
+		return oidNamedCurveP384, true
+	case elliptic.P521():
+		return oidNamedCurveP521, true
+	}
+	return nil, false
+}
+
+func curveFromOID(oid encoding_asn1.ObjectIdentifier) (elliptic.Curve, bool) {
+	switch {
+	case oid.Equal(oidNamedCurveP224):
+		return elliptic.P224(), true
+	case oid.Equal(oidNamedCurveP256):
+		return elliptic.P256(), true
+	case oid.Equal(oidNamedCurveP384):
+		return elliptic.P384(), true
+	case oid.Equal(oidNamedCurveP521):
+		return elliptic.P521(), true
+	}
+	return nil, false
+}
+
+// Marshal encodes rs as an ASN.1 DER structure:
+//
+//	RingSign ::= SEQUENCE {
+//		tau  OCTET STRING, -- SEC1 uncompressed point of Hs
+//		c    SEQUENCE OF INTEGER,
+//		t    SEQUENCE OF INTEGER
+//	}
+//
+// rs.Curve is used to encode tau, since RingSign's wire format does not
+// carry curve information itself.
+func (rs *RingSign) Marshal() ([]byte, error) {
+	if rs.Hsx == nil || rs.Hsy == nil {
+		return nil, errors.New("urs: RingSign has no tau")
+	}
+	if len(rs.C) == 0 || len(rs.C) != len(rs.T) {
+		return nil, errors.New("urs: RingSign has mismatched c/t lengths")
+	}
+	if rs.Curve == nil {
+		return nil, errors.New("urs: RingSign has no curve")
+	}
+	tau := elliptic.Marshal(rs.Curve, rs.Hsx, rs.Hsy)
+
+	var b cryptobyte.Builder
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1OctetString(tau)
+		b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			for _, c := range rs.C {
+				b.AddASN1BigInt(c)
+			}
+		})
+		b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			for _, t := range rs.T {
+				b.AddASN1BigInt(t)
+			}
+		})
+	})
+	return b.Bytes()
+}
+
+// UnmarshalRingSign parses a RingSign encoded by Marshal. curve must be the
+// curve used by the ring the signature was produced against, and is used to
+// decode and validate tau. UnmarshalRingSign rejects negative integers,
+// mismatched c/t lengths, an off-curve tau, and trailing bytes.
+func UnmarshalRingSign(curve elliptic.Curve, der []byte) (*RingSign, error) {
+	input := cryptobyte.String(der)
+	var inner cryptobyte.String
+	if !input.ReadASN1(&inner, casn1.SEQUENCE) || !input.Empty() {
+		return nil, errors.New("urs: invalid ASN.1 RingSign")
+	}
+
+	var tau []byte
+	if !inner.ReadASN1Bytes(&tau, casn1.OCTET_STRING) {
+		return nil, errors.New("urs: invalid ASN.1 tau")
+	}
+	x, y := elliptic.Unmarshal(curve, tau)
+	if x == nil {
+		return nil, errors.New("urs: tau is not a valid point on the curve")
+	}
+
+	var cSeq, tSeq cryptobyte.String
+	if !inner.ReadASN1(&cSeq, casn1.SEQUENCE) {
+		return nil, errors.New("urs: invalid ASN.1 c")
+	}
+	var c []*big.Int
+	for !cSeq.Empty() {
+		v := new(big.Int)
+		if !cSeq.ReadASN1Integer(v) || v.Sign() < 0 {
+			return nil, errors.New("urs: invalid ASN.1 c value")
+		}
+		c = append(c, v)
+	}
+
+	if !inner.ReadASN1(&tSeq, casn1.SEQUENCE) {
+		return nil, errors.New("urs: invalid ASN.1 t")
+	}
+	var t []*big.Int
+	for !tSeq.Empty() {
+		v := new(big.Int)
+		if !tSeq.ReadASN1Integer(v) || v.Sign() < 0 {
+			return nil, errors.New("urs: invalid ASN.1 t value")
+		}
+		t = append(t, v)
+	}
+
+	if len(c) == 0 || len(c) != len(t) {
+		return nil, errors.New("urs: mismatched c/t lengths")
+	}
+	if !inner.Empty() {
+		return nil, errors.New("urs: trailing data after RingSign")
+	}
+	return &RingSign{x, y, c, t, curve}, nil
+}
+
+// Marshal encodes r as an ASN.1 DER structure:
+//
+//	PublicKeyRing ::= SEQUENCE {
+//		curve  OBJECT IDENTIFIER,
+//		keys   SEQUENCE OF OCTET STRING -- SEC1 uncompressed points
+//	}
+//
+// This gives the ring a stable interchange format, unlike the ad-hoc
+// concatenation in Bytes, which strips leading zero bytes (via
+// big.Int.Bytes) and so cannot be unambiguously parsed back.
+func (r *PublicKeyRing) Marshal() ([]byte, error) {
+	if r.Len() == 0 {
+		return nil, errors.New("urs: empty public key ring")
+	}
+	curve := r.Ring[0].Curve
+	oid, ok := oidFromCurve(curve)
+	if !ok {
+		return nil, errors.New("urs: unsupported curve")
+	}
+
+	var b cryptobyte.Builder
+	b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oid)
+		b.AddASN1(casn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			for _, pub := range r.Ring {
+				b.AddASN1OctetString(elliptic.Marshal(curve, pub.X, pub.Y))
+			}
+		})
+	})
+	return b.Bytes()
+}
+
+// UnmarshalPublicKeyRing parses a PublicKeyRing encoded by Marshal,
+// rejecting an unrecognized curve OID, an off-curve key point, or trailing
+// bytes.
+func UnmarshalPublicKeyRing(der []byte) (*PublicKeyRing, error) {
+	input := cryptobyte.String(der)
+	var inner cryptobyte.String
+	if !input.ReadASN1(&inner, casn1.SEQUENCE) || !input.Empty() {
+		return nil, errors.New("urs: invalid ASN.1 PublicKeyRing")
+	}
+
+	var oid encoding_asn1.ObjectIdentifier
+	if !inner.ReadASN1ObjectIdentifier(&oid) {
+		return nil, errors.New("urs: invalid ASN.1 curve OID")
+	}
+	curve, ok := curveFromOID(oid)
+	if !ok {
+		return nil, errors.New("urs: unsupported curve OID")
+	}
+
+	var keys cryptobyte.String
+	if !inner.ReadASN1(&keys, casn1.SEQUENCE) {
+		return nil, errors.New("urs: invalid ASN.1 keys")
+	}
+	r := NewPublicKeyRing(0)
+	for !keys.Empty() {
+		var pt []byte
+		if !keys.ReadASN1Bytes(&pt, casn1.OCTET_STRING) {
+			return nil, errors.New("urs: invalid ASN.1 key point")
+		}
+		x, y := elliptic.Unmarshal(curve, pt)
+		if x == nil {
+			return nil, errors.New("urs: key point is not on the curve")
+		}
+		if err := r.Add(PublicKey{curve, x, y}); err != nil {
+			return nil, err
+		}
+	}
+	if !inner.Empty() {
+		return nil, errors.New("urs: trailing data after PublicKeyRing")
+	}
+	return r, nil
+}
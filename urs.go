@@ -14,12 +14,21 @@ package urs
 //     http://www.secg.org/download/aid-780/sec1-v2.pdf
 
 import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/elliptic"
 	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
 	"bytes"
+	"errors"
 	"io"
 	"fmt"
 	"math/big"
+	"runtime"
+	"sort"
+	"sync"
 )
 
 // PublicKey corresponds to a ECDSA public key.
@@ -45,10 +54,26 @@ func NewPublicKeyRing(cap uint) *PublicKeyRing {
 	return &PublicKeyRing{make([]PublicKey, 0, cap)}
 }
 
-// Add adds a public key, pub to the ring.
-// All keys added to the ring must use the same curve.
-func (r *PublicKeyRing) Add(pub PublicKey) {
+// Add adds a public key, pub, to the ring. It returns an error, and leaves
+// the ring unchanged, if pub is malformed (a nil or out-of-range
+// coordinate, or a point not on the curve) or uses a different curve than
+// the keys already in the ring.
+func (r *PublicKeyRing) Add(pub PublicKey) error {
+	if pub.Curve == nil || pub.X == nil || pub.Y == nil {
+		return errors.New("urs: public key missing curve or coordinates")
+	}
+	P := pub.Curve.Params().P
+	if pub.X.Sign() <= 0 || pub.X.Cmp(P) >= 0 || pub.Y.Sign() <= 0 || pub.Y.Cmp(P) >= 0 {
+		return errors.New("urs: public key coordinates out of range")
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return errors.New("urs: public key is not on the curve")
+	}
+	if len(r.Ring) > 0 && r.Ring[0].Curve != pub.Curve {
+		return errors.New("urs: public key uses a different curve than the ring")
+	}
 	r.Ring = append(r.Ring, pub)
+	return nil
 }
 
 // Len returns the length of ring.
@@ -69,6 +94,66 @@ func (k PublicKey) String() string {
 	return fmt.Sprintf("X(%s)\nY(%s)\n", k.X, k.Y)
 }
 
+// Equal reports whether pub and x have the same value.
+func (pub *PublicKey) Equal(x crypto.PublicKey) bool {
+	xx, ok := x.(*PublicKey)
+	if !ok {
+		return false
+	}
+	return pub.Curve == xx.Curve &&
+		bigIntEqual(pub.X, xx.X) && bigIntEqual(pub.Y, xx.Y)
+}
+
+// Public returns the public key corresponding to priv.
+func (priv *PrivateKey) Public() crypto.PublicKey {
+	return &priv.PublicKey
+}
+
+// Equal reports whether priv and x have the same value.
+//
+// Two keys are only considered to have the same value if they have the
+// same Curve value, as that of crypto/ecdsa.
+func (priv *PrivateKey) Equal(x crypto.PrivateKey) bool {
+	xx, ok := x.(*PrivateKey)
+	if !ok {
+		return false
+	}
+	return priv.PublicKey.Equal(&xx.PublicKey) && bigIntEqual(priv.D, xx.D)
+}
+
+// bigIntEqual reports whether a and b have the same value, leaking only
+// their bit lengths through timing.
+func bigIntEqual(a, b *big.Int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return subtle.ConstantTimeCompare(a.Bytes(), b.Bytes()) == 1
+}
+
+// RingSigner adapts a PrivateKey and the PublicKeyRing it signs within to
+// the crypto.Signer interface.
+type RingSigner struct {
+	Priv *PrivateKey
+	Ring *PublicKeyRing
+}
+
+// Public returns the public key of the ring member rs signs as.
+func (rs *RingSigner) Public() crypto.PublicKey {
+	return rs.Priv.Public()
+}
+
+// Sign signs digest using rs.Priv within rs.Ring, and returns the ASN.1 DER
+// encoding of the resulting RingSign (see RingSign.Marshal). rand is used
+// to derive the signature's hedged nonce, as in Sign; opts is accepted to
+// satisfy crypto.Signer but is otherwise unused.
+func (rs *RingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	sig, err := Sign(rand, rs.Priv, rs.Ring, digest)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Marshal()
+}
+
 var one = new(big.Int).SetInt64(1)
 
 // randFieldElement returns a random element of the field underlying the given
@@ -126,6 +211,63 @@ func hashToInt(hash []byte, c elliptic.Curve) *big.Int {
 type RingSign struct {
 	Hsx, Hsy *big.Int
 	C, T []*big.Int
+
+	// Curve is the curve of the ring rs was produced against. Tag and
+	// Marshal use it to encode tau; it is not part of the wire format (see
+	// RingSign.Marshal), since it is implied by the ring.
+	Curve elliptic.Curve
+}
+
+// Tag returns the SEC1-encoded uncompressed point tau = H(mR)^x, where x is
+// the private key of the ring member that produced rs.
+//
+// Tag is deterministic for a given (m, R, signer): two signatures over the
+// same message and ring from the same ring member always produce the same
+// tag. This is the basis of URS's linkability guarantee, and its scope is
+// exactly that — same message, same ring. A signer using a different
+// message or a different ring produces an unrelated tag, so Tag must not
+// be used as a general-purpose key image. See DetectDoubleSign and
+// LinkTag, which gate access to the tag behind signature verification.
+func (rs *RingSign) Tag() []byte {
+	return elliptic.Marshal(rs.Curve, rs.Hsx, rs.Hsy)
+}
+
+// DetectDoubleSign groups the indices of sigs whose tags collide, after
+// individually verifying each signature against R and m. Signatures that
+// fail to verify are ignored. Two indices appearing in the same group were
+// produced over (m, R) by the same ring member.
+func DetectDoubleSign(R *PublicKeyRing, m []byte, sigs []*RingSign) [][]int {
+	byTag := make(map[string][]int)
+	for i, rs := range sigs {
+		if rs == nil || !Verify(R, m, rs) {
+			continue
+		}
+		tag := string(rs.Tag())
+		byTag[tag] = append(byTag[tag], i)
+	}
+
+	var groups [][]int
+	for _, idxs := range byTag {
+		if len(idxs) > 1 {
+			groups = append(groups, idxs)
+		}
+	}
+	// byTag is a map, so the order groups were appended in is
+	// nondeterministic across calls with identical input; sort by each
+	// group's first index for stable, reproducible output.
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
+
+// LinkTag verifies rs against R and m, and only if verification succeeds
+// returns its linkability tag (see RingSign.Tag). Gating the tag behind
+// Verify prevents a caller from being tricked into linking on an
+// unverified, potentially forged signature.
+func LinkTag(R *PublicKeyRing, m []byte, rs *RingSign) ([]byte, bool) {
+	if !Verify(R, m, rs) {
+		return nil, false
+	}
+	return rs.Tag(), true
 }
 
 // this is just for debugging; we probably don't want this for anything else
@@ -157,12 +299,76 @@ func hashq(m []byte) (d []byte) {
 	return
 }
 
+// hedgedRand is a cipher.Stream wrapped as an io.Reader, used to draw all of
+// the randomness Sign needs from a CSPRNG that is derived from the private
+// key and the message being signed, rather than from rand directly.
+type hedgedRand struct {
+	stream cipher.Stream
+}
+
+func (h hedgedRand) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	h.stream.XORKeyStream(p, p)
+	return len(p), nil
+}
+
+// newHedgedRand derives an AES-CTR CSPRNG keyed by
+// SHA-512(priv.D || entropy || H(m||R.Bytes()))[:32] with a fixed IV, after
+// reading params.BitSize/8+8 bytes of entropy from rand up front. This
+// mirrors the hedged nonce construction in Go's own crypto/ecdsa: even if
+// rand turns out to be broken or low-entropy, the CSPRNG output remains as
+// unpredictable as the private key and message allow, while still being
+// non-deterministic when rand is healthy.
+func newHedgedRand(rand io.Reader, priv *PrivateKey, R *PublicKeyRing, m []byte) (io.Reader, error) {
+	params := priv.Curve.Params()
+	entropy := make([]byte, params.BitSize/8+8)
+	if _, err := io.ReadFull(rand, entropy); err != nil {
+		return nil, err
+	}
+
+	mR := make([]byte, 0, len(m)+len(R.Bytes()))
+	mR = append(mR, m...)
+	mR = append(mR, R.Bytes()...)
+
+	h := sha512.New()
+	h.Write(priv.D.Bytes())
+	h.Write(entropy)
+	h.Write(hashq(mR))
+	key := h.Sum(nil)[:32]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	return hedgedRand{cipher.NewCTR(block, iv)}, nil
+}
+
 // Sign signs an arbitrary length message (which should NOT be the hash of a
-// larger message) using the private key, priv and the public key ring, ring. 
+// larger message) using the private key, priv and the public key ring, ring.
 // It returns the signature as a struct of type RingSign.
 // The security of the private key depends on the entropy of rand.
 // The public keys in the ring must all be using the same curve.
+//
+// Sign hedges against a broken or low-entropy rand by deriving its internal
+// randomness from priv and m as well; see SignHedged.
 func Sign(rand io.Reader, priv *PrivateKey, R *PublicKeyRing, m []byte) (rs *RingSign, err error) {
+	return SignHedged(rand, priv, R, m)
+}
+
+// SignHedged is like Sign, but makes the hedged nonce derivation explicit:
+// every scalar Sign would otherwise draw from rand (all c[j] and t[j] for
+// j != id, and r) is instead drawn from a CSPRNG seeded from priv, R, m and
+// a fixed amount of entropy read from rand. Sign is implemented in terms of
+// SignHedged.
+func SignHedged(rand io.Reader, priv *PrivateKey, R *PublicKeyRing, m []byte) (rs *RingSign, err error) {
+	rand, err = newHedgedRand(rand, priv, R, m)
+	if err != nil {
+		return nil, err
+	}
+
 	s := R.Len()
 	ax := make([]*big.Int, s, s)
 	ay := make([]*big.Int, s, s)
@@ -174,15 +380,23 @@ func Sign(rand io.Reader, priv *PrivateKey, R *PublicKeyRing, m []byte) (rs *Rin
 	curve := pub.Curve
 	N := curve.Params().N
 
-	mR := append(m, R.Bytes()...)
+	mR := make([]byte, 0, len(m)+len(R.Bytes()))
+	mR = append(mR, m...)
+	mR = append(mR, R.Bytes()...)
 	hx, hy := hashG(curve, mR) // H(mR)
 	hsx, hsy := curve.ScalarMult(hx, hy, priv.D.Bytes()) // Step 4: H(mR)^xi
 
 	var id int
+	foundSelf := false
 	sum := new(big.Int).SetInt64(0)
 	for j := 0; j < s; j++ {
-		if R.Ring[j] == pub {
+		// Only the first occurrence of pub in the ring is treated as the
+		// signer's own slot; if pub appears again (a duplicate ring entry)
+		// later occurrences are filled in like any other ring member so
+		// every slot's c[j]/t[j]/ax[j]/ay[j]/bx[j]/by[j] gets populated.
+		if !foundSelf && R.Ring[j] == pub {
 			id = j
+			foundSelf = true
 		} else {
 			c[j], err = randFieldElement(curve, rand)
 			if err != nil {
@@ -237,54 +451,98 @@ func Sign(rand io.Reader, priv *PrivateKey, R *PublicKeyRing, m []byte) (rs *Rin
 	t[id].Sub(r, cx)
 	t[id].Mod(t[id], N)
 
-	return &RingSign{hsx, hsy, c, t}, nil
+	return &RingSign{hsx, hsy, c, t, curve}, nil
+}
+
+// combinedMult is implemented by curves (such as elliptic.P256) that can
+// compute baseScalar*G + scalar*(bigX,bigY) more efficiently than a
+// ScalarBaseMult/ScalarMult/Add sequence. It matches the unexported
+// interface crypto/ecdsa detects on elliptic.Curve for the same purpose.
+type combinedMult interface {
+	CombinedMult(bigX, bigY *big.Int, baseScalar, scalar []byte) (x, y *big.Int)
 }
 
 // Verify verifies the signature in r, s of hash using the public key, pub. Its
 // return value records whether the signature is valid.
+//
+// The per-ring-member checks are independent until their results are hashed
+// together at the end, so Verify spreads them across a worker pool sized to
+// GOMAXPROCS, and uses the curve's CombinedMult (if it implements one) to
+// fold each g^tj * yj^cj computation into a single call.
 func Verify(R *PublicKeyRing, m []byte, rs *RingSign) bool {
 	s := R.Len()
-	if s == 0 {
+	if s == 0 || len(rs.C) != s || len(rs.T) != s {
 		return false
 	}
 	c := R.Ring[0].Curve
 	N := c.Params().N
+	P := c.Params().P
 	x := rs.Hsx
 	y := rs.Hsy
 
-	if x.Sign() == 0 || y.Sign() == 0 {
+	if x == nil || y == nil || x.Sign() <= 0 || y.Sign() <= 0 {
 		return false
 	}
-	if x.Cmp(N) >= 0 || y.Cmp(N) >= 0 {
+	if x.Cmp(P) >= 0 || y.Cmp(P) >= 0 {
 		return false
 	}
 	// 1. Tau is on curve
 	if !c.IsOnCurve(x, y) {
 		return false
 	}
-	mR := append(m, R.Bytes()...)
+
+	sum := new(big.Int)
+	for j := 0; j < s; j++ {
+		// 2. Check that cj,tj is in range [1..N)
+		if rs.C[j].Sign() <= 0 || rs.T[j].Sign() <= 0 || rs.C[j].Cmp(N) >= 0 || rs.T[j].Cmp(N) >= 0 {
+			return false
+		}
+		sum.Add(sum, rs.C[j])
+	}
+
+	mR := make([]byte, 0, len(m)+len(R.Bytes()))
+	mR = append(mR, m...)
+	mR = append(mR, R.Bytes()...)
 	hx, hy := hashG(c, mR)
 
-	sum := new(big.Int).SetInt64(0)
+	cm, _ := c.(combinedMult)
 	ax := make([]*big.Int, s, s)
 	ay := make([]*big.Int, s, s)
 	bx := make([]*big.Int, s, s)
 	by := make([]*big.Int, s, s)
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > s {
+		workers = s
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				tb := rs.T[j].Bytes()
+				cb := rs.C[j].Bytes()
+				if cm != nil {
+					ax[j], ay[j] = cm.CombinedMult(R.Ring[j].X, R.Ring[j].Y, tb, cb)
+				} else {
+					ax1, ay1 := c.ScalarBaseMult(tb)                      // g^tj
+					ax2, ay2 := c.ScalarMult(R.Ring[j].X, R.Ring[j].Y, cb) // yj^cj
+					ax[j], ay[j] = c.Add(ax1, ay1, ax2, ay2)
+				}
+				bx1, by1 := c.ScalarMult(hx, hy, tb) // H(mR)^tj
+				bx2, by2 := c.ScalarMult(x, y, cb)    // tau^cj
+				bx[j], by[j] = c.Add(bx1, by1, bx2, by2)
+			}
+		}()
+	}
 	for j := 0; j < s; j++ {
-		// 2. Check that cj,tj is in range [0..N]
-		if rs.C[j].Cmp(N) >= 0 || rs.T[j].Cmp(N) >= 0 {
-			return false
-		}
-		tb := rs.T[j].Bytes()
-		cb := rs.C[j].Bytes()
-		ax1, ay1 := c.ScalarBaseMult(tb) // g^tj
-		ax2, ay2 := c.ScalarMult(R.Ring[j].X, R.Ring[j].Y, cb) // yj^cj
-		ax[j], ay[j] = c.Add(ax1, ay1, ax2, ay2)
-		bx1, by1 := c.ScalarMult(hx, hy, tb) // H(mR)^tj
-		bx2, by2 := c.ScalarMult(x, y, cb) // tau^cj
-		bx[j], by[j] = c.Add(bx1, by1, bx2, by2)
-		sum.Add(sum, rs.C[j])
+		jobs <- j
 	}
+	close(jobs)
+	wg.Wait()
+
 	// 3. Check signature...
 	mRab := make([]byte, 0)
 	mRab = append(mRab, mR...)
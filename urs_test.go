@@ -1,9 +1,12 @@
 package urs
 
 import (
+	"crypto"
 	"fmt"
+	"io"
 	"testing"
 	"runtime"
+	"math/big"
 	"math/rand"
 	crand "crypto/rand"
 	"crypto/elliptic"
@@ -31,7 +34,9 @@ func TestGenerateKey(t *testing.T) {
 
 func TestNewPublicKeyRing(t *testing.T) {
 	keyring = NewPublicKeyRing(1)
-	keyring.Add(testkey.PublicKey)
+	if err := keyring.Add(testkey.PublicKey); err != nil {
+		t.Fatal(err)
+	}
 	expectedLen := 1
 	if len(keyring.Ring) != expectedLen {
 		t.Errorf("len(keyring)=%d, expected %d", len(keyring.Ring), expectedLen)
@@ -53,7 +58,9 @@ func TestPopulateKeyRing(t *testing.T) {
 			testkey = key
 		}
 		// add the public key part to the ring
-		keyring.Add(key.PublicKey)
+		if err := keyring.Add(key.PublicKey); err != nil {
+			t.Fatal(err)
+		}
 	}
 	if len(keyring.Ring) != numOfKeys {
 		t.Errorf("len(keyring)=%d, expected %d", len(keyring.Ring), numOfKeys)
@@ -78,6 +85,379 @@ func TestVerify(t *testing.T) {
 	}
 }
 
+// TestSignHedgedSurvivesExhaustedRand checks that SignHedged still produces
+// a valid signature when rand is only good for the single up-front entropy
+// draw and returns io.EOF on every read after that, since every scalar the
+// original Sign drew from rand directly is now drawn from the derived
+// CSPRNG instead.
+func TestSignHedgedSurvivesExhaustedRand(t *testing.T) {
+	entropyLen := DefaultCurve.Params().BitSize/8 + 8
+	r := io.LimitReader(crand.Reader, int64(entropyLen))
+	msg := []byte("hedged against a broken rand")
+	sig, err := SignHedged(r, testkey, keyring, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(keyring, msg, sig) {
+		t.Error("urs: hedged signature failed to verify")
+	}
+}
+
+// TestSignHedgedFailsOnInsufficientEntropy checks that SignHedged still
+// reports an error, rather than silently proceeding, when rand cannot even
+// supply the up-front entropy draw.
+func TestSignHedgedFailsOnInsufficientEntropy(t *testing.T) {
+	r := io.LimitReader(crand.Reader, 0)
+	if _, err := SignHedged(r, testkey, keyring, testmsg); err == nil {
+		t.Error("urs: expected error when rand cannot supply entropy")
+	}
+}
+
+func TestRingSignMarshalRoundTrip(t *testing.T) {
+	sig, err := Sign(crand.Reader, testkey, keyring, testmsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := sig.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnmarshalRingSign(DefaultCurve, der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hsx.Cmp(sig.Hsx) != 0 || got.Hsy.Cmp(sig.Hsy) != 0 {
+		t.Error("urs: tau mismatch after round-trip")
+	}
+	if len(got.C) != len(sig.C) || len(got.T) != len(sig.T) {
+		t.Fatal("urs: c/t length mismatch after round-trip")
+	}
+	for i := range sig.C {
+		if got.C[i].Cmp(sig.C[i]) != 0 {
+			t.Errorf("urs: c[%d] mismatch after round-trip", i)
+		}
+		if got.T[i].Cmp(sig.T[i]) != 0 {
+			t.Errorf("urs: t[%d] mismatch after round-trip", i)
+		}
+	}
+	if !Verify(keyring, testmsg, got) {
+		t.Error("urs: round-tripped signature failed to verify")
+	}
+}
+
+func TestUnmarshalRingSignRejectsTrailingBytes(t *testing.T) {
+	sig, err := Sign(crand.Reader, testkey, keyring, testmsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := sig.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UnmarshalRingSign(DefaultCurve, append(der, 0x00)); err == nil {
+		t.Error("urs: expected error for trailing bytes")
+	}
+}
+
+func TestPublicKeyRingMarshalRoundTrip(t *testing.T) {
+	der, err := keyring.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnmarshalPublicKeyRing(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Len() != keyring.Len() {
+		t.Fatalf("urs: len(ring)=%d, expected %d", got.Len(), keyring.Len())
+	}
+	for i := range keyring.Ring {
+		if got.Ring[i].X.Cmp(keyring.Ring[i].X) != 0 || got.Ring[i].Y.Cmp(keyring.Ring[i].Y) != 0 {
+			t.Errorf("urs: ring[%d] mismatch after round-trip", i)
+		}
+	}
+}
+
+func TestPrivateKeyAndPublicKeyEqual(t *testing.T) {
+	k1, err := GenerateKey(DefaultCurve, crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := GenerateKey(DefaultCurve, crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !k1.Equal(k1) {
+		t.Error("urs: private key does not equal itself")
+	}
+	if k1.Equal(k2) {
+		t.Error("urs: distinct private keys compared equal")
+	}
+	if !k1.PublicKey.Equal(&k1.PublicKey) {
+		t.Error("urs: public key does not equal itself")
+	}
+	if k1.PublicKey.Equal(&k2.PublicKey) {
+		t.Error("urs: distinct public keys compared equal")
+	}
+}
+
+func TestRingSignerImplementsCryptoSigner(t *testing.T) {
+	var _ crypto.Signer = (*RingSigner)(nil)
+
+	signer := &RingSigner{Priv: testkey, Ring: keyring}
+	der, err := signer.Sign(crand.Reader, testmsg, crypto.Hash(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := UnmarshalRingSign(DefaultCurve, der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(keyring, testmsg, sig) {
+		t.Error("urs: RingSigner-produced signature failed to verify")
+	}
+	pub, ok := signer.Public().(*PublicKey)
+	if !ok || !pub.Equal(&testkey.PublicKey) {
+		t.Error("urs: RingSigner.Public() does not match the signing key")
+	}
+}
+
+func TestLinkTagAndDetectDoubleSign(t *testing.T) {
+	r := NewPublicKeyRing(3)
+	var signer *PrivateKey
+	for i := 0; i < 3; i++ {
+		key, err := GenerateKey(DefaultCurve, crand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			signer = key
+		}
+		if err := r.Add(key.PublicKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg := []byte("link me")
+
+	sig1, err := Sign(crand.Reader, signer, r, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig2, err := Sign(crand.Reader, signer, r, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag1, ok := LinkTag(r, msg, sig1)
+	if !ok {
+		t.Fatal("urs: LinkTag rejected a valid signature")
+	}
+	tag2, ok := LinkTag(r, msg, sig2)
+	if !ok {
+		t.Fatal("urs: LinkTag rejected a valid signature")
+	}
+	if string(tag1) != string(tag2) {
+		t.Error("urs: two signatures by the same signer over (m, R) produced different tags")
+	}
+
+	groups := DetectDoubleSign(r, msg, []*RingSign{sig1, sig2})
+	if len(groups) != 1 || len(groups[0]) != 2 {
+		t.Fatalf("urs: DetectDoubleSign groups = %v, expected one group of 2", groups)
+	}
+
+	// With more than one double-signing ring member, groups must come back
+	// sorted by first index, not in the nondeterministic order map
+	// iteration would otherwise produce.
+	signer2, err := GenerateKey(DefaultCurve, crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2 := NewPublicKeyRing(4)
+	if err := r2.Add(signer.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.Add(signer2.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	for _, pub := range r.Ring[1:] {
+		if err := r2.Add(pub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sigA1, err := Sign(crand.Reader, signer, r2, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigB1, err := Sign(crand.Reader, signer2, r2, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigB2, err := Sign(crand.Reader, signer2, r2, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigA2, err := Sign(crand.Reader, signer, r2, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Index order: [0]=sigA1 [1]=sigB1 [2]=sigB2 [3]=sigA2. Group A's first
+	// index (0) is lower than group B's (1), so A must sort first. Run
+	// several times since map iteration order varies per process.
+	for i := 0; i < 5; i++ {
+		groups2 := DetectDoubleSign(r2, msg, []*RingSign{sigA1, sigB1, sigB2, sigA2})
+		if len(groups2) != 2 {
+			t.Fatalf("urs: DetectDoubleSign groups = %v, expected two groups", groups2)
+		}
+		if groups2[0][0] != 0 || groups2[1][0] != 1 {
+			t.Fatalf("urs: DetectDoubleSign groups = %v, expected sorted by first index ([0,3] before [1,2])", groups2)
+		}
+	}
+
+	// An unverifiable (tampered) signature must not be linkable or grouped.
+	tampered := &RingSign{
+		Hsx:   new(big.Int).Set(sig1.Hsx),
+		Hsy:   new(big.Int).Set(sig1.Hsy),
+		C:     cloneBigInts(sig1.C),
+		T:     cloneBigInts(sig1.T),
+		Curve: sig1.Curve,
+	}
+	tampered.C[0].Add(tampered.C[0], one)
+	if _, ok := LinkTag(r, msg, tampered); ok {
+		t.Error("urs: LinkTag returned a tag for an unverified signature")
+	}
+	if groups := DetectDoubleSign(r, msg, []*RingSign{sig1, tampered}); len(groups) != 0 {
+		t.Errorf("urs: DetectDoubleSign grouped an unverified signature: %v", groups)
+	}
+}
+
+func TestPublicKeyRingAddRejectsMalformedKeys(t *testing.T) {
+	good, err := GenerateKey(DefaultCurve, crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCurveKey, err := GenerateKey(elliptic.P384(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		pub     PublicKey
+		wantErr bool
+	}{
+		{"valid key", good.PublicKey, false},
+		{"nil X", PublicKey{DefaultCurve, nil, good.Y}, true},
+		{"nil Y", PublicKey{DefaultCurve, good.X, nil}, true},
+		{"zero X", PublicKey{DefaultCurve, new(big.Int), good.Y}, true},
+		{"X out of range", PublicKey{DefaultCurve, new(big.Int).Set(DefaultCurve.Params().P), good.Y}, true},
+		{"off curve", PublicKey{DefaultCurve, good.X, new(big.Int).Add(good.Y, one)}, true},
+		{"different curve", otherCurveKey.PublicKey, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewPublicKeyRing(1)
+			if err := r.Add(good.PublicKey); err != nil {
+				t.Fatal(err)
+			}
+			err := r.Add(tt.pub)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Add() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsMalformedSignatures(t *testing.T) {
+	r := NewPublicKeyRing(3)
+	var signer *PrivateKey
+	for i := 0; i < 3; i++ {
+		key, err := GenerateKey(DefaultCurve, crand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			signer = key
+		}
+		if err := r.Add(key.PublicKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg := []byte("tamper me")
+	sig, err := Sign(crand.Reader, signer, r, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(r, msg, sig) {
+		t.Fatal("urs: valid signature failed to verify")
+	}
+
+	tests := []struct {
+		name    string
+		corrupt func(*RingSign)
+	}{
+		{"tampered tau", func(rs *RingSign) { rs.Hsx.Add(rs.Hsx, one) }},
+		{"tampered c", func(rs *RingSign) { rs.C[0].Add(rs.C[0], one) }},
+		{"tampered t", func(rs *RingSign) { rs.T[0].Add(rs.T[0], one) }},
+		{"zero c", func(rs *RingSign) { rs.C[0].SetInt64(0) }},
+		{"zero t", func(rs *RingSign) { rs.T[0].SetInt64(0) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := &RingSign{
+				Hsx:   new(big.Int).Set(sig.Hsx),
+				Hsy:   new(big.Int).Set(sig.Hsy),
+				C:     cloneBigInts(sig.C),
+				T:     cloneBigInts(sig.T),
+				Curve: sig.Curve,
+			}
+			tt.corrupt(tampered)
+			if Verify(r, msg, tampered) {
+				t.Error("urs: tampered signature verified")
+			}
+		})
+	}
+}
+
+func TestVerifyDuplicateRingEntries(t *testing.T) {
+	signer, err := GenerateKey(DefaultCurve, crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewPublicKeyRing(3)
+	if err := r.Add(signer.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(signer.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	other, err := GenerateKey(DefaultCurve, crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(other.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := []byte("duplicate ring entry")
+	sig, err := Sign(crand.Reader, signer, r, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(r, msg, sig) {
+		t.Error("urs: signature over a ring with duplicate entries failed to verify")
+	}
+}
+
+func cloneBigInts(in []*big.Int) []*big.Int {
+	out := make([]*big.Int, len(in))
+	for i, v := range in {
+		out[i] = new(big.Int).Set(v)
+	}
+	return out
+}
+
 func BenchmarkSign(b *testing.B) {
 	runtime.GOMAXPROCS(8)
 	var err error
@@ -99,3 +479,38 @@ func BenchmarkVerify(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkVerifyScaling measures how Verify's parallel worker pool and
+// CombinedMult fast path scale as the ring grows from 128 to 4096 keys.
+func BenchmarkVerifyScaling(b *testing.B) {
+	runtime.GOMAXPROCS(8)
+	for _, n := range []int{128, 256, 512, 1024, 2048, 4096} {
+		b.Run(fmt.Sprintf("keys=%d", n), func(b *testing.B) {
+			ring := NewPublicKeyRing(uint(n))
+			var priv *PrivateKey
+			for i := 0; i < n; i++ {
+				key, err := GenerateKey(DefaultCurve, crand.Reader)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if i == 0 {
+					priv = key
+				}
+				if err := ring.Add(key.PublicKey); err != nil {
+					b.Fatal(err)
+				}
+			}
+			msg := []byte("benchmark message")
+			sig, err := Sign(crand.Reader, priv, ring, msg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if !Verify(ring, msg, sig) {
+					b.Fatal("urs: signature verification failed")
+				}
+			}
+		})
+	}
+}